@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are labeled by addr (the host actually saved/scraped) and group
+// (see target.group), so a dashboard can both drill into a single host and
+// roll up by replica set / cluster shard.
+var (
+	metricLastSaveTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsm_last_save_timestamp_seconds",
+		Help: "Unix timestamp of the last BGSAVE redis-save-manager completed successfully on this host.",
+	}, []string{"addr", "group"})
+
+	metricSaveDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rsm_save_duration_seconds",
+		Help:    "Time spent waiting for BGSAVE to finish.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"addr", "group"})
+
+	metricSaveFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rsm_save_failures_total",
+		Help: "Number of BGSAVE attempts that failed or were interrupted.",
+	}, []string{"addr", "group"})
+
+	metricPersistenceDisabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsm_persistence_disabled",
+		Help: "1 if redis-save-manager has confirmed automatic persistence is disabled on this host, 0 otherwise.",
+	}, []string{"addr", "group"})
+
+	metricBGSaveInProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsm_bgsave_in_progress",
+		Help: "1 while a BGSAVE issued by redis-save-manager is in flight on this host.",
+	}, []string{"addr", "group"})
+
+	metricUsedMemory = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsm_redis_used_memory_bytes",
+		Help: "used_memory as last reported by INFO memory.",
+	}, []string{"addr", "group"})
+
+	metricRDBLastStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rsm_redis_rdb_last_bgsave_status",
+		Help: "1 if rdb_last_bgsave_status as last reported by INFO persistence is ok, 0 otherwise.",
+	}, []string{"addr", "group"})
+
+	metricBackupsUploaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rsm_backups_uploaded_total",
+		Help: "Number of post-save backups successfully uploaded.",
+	}, []string{"addr", "group"})
+
+	metricBackupsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rsm_backups_failed_total",
+		Help: "Number of post-save backup uploads that failed.",
+	}, []string{"addr", "group"})
+)
+
+// serveMetrics blocks serving Prometheus metrics on addr until the process
+// exits; it is started in its own goroutine from main when -metrics-addr is
+// set.
+func serveMetrics(addr string, log *slog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Error("metrics server stopped", "addr", addr, "error", http.ListenAndServe(addr, mux))
+}
+
+// scrapeRedisMetrics updates the rsm_redis_* gauges for t by issuing INFO
+// memory and INFO persistence. Scrape failures are not logged: they are
+// expected whenever a host is briefly unreachable and shouldn't be as noisy
+// as a failed save.
+func scrapeRedisMetrics(ctx context.Context, t target) {
+	labels := []string{t.addr, t.group()}
+	if info, err := t.client.Info(ctx, "memory").Result(); err == nil {
+		if v, ok := infoField(info, "used_memory"); ok {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				metricUsedMemory.WithLabelValues(labels...).Set(n)
+			}
+		}
+	}
+	if info, err := t.client.Info(ctx, "persistence").Result(); err == nil {
+		if v, ok := infoField(info, "rdb_last_bgsave_status"); ok {
+			metricRDBLastStatus.WithLabelValues(labels...).Set(boolToFloat(v == "ok"))
+		}
+	}
+}
+
+// infoField extracts the value of "field:value" from the text returned by
+// an INFO command.
+func infoField(info, field string) (string, bool) {
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		k, v, ok := strings.Cut(line, ":")
+		if ok && k == field {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -1,77 +1,182 @@
 // Command redis-save-manager disables automatic persistence on a set of redis
-// hosts and then runs BGSAVE on them sequentially in a loop, waiting for save
-// to complete so that only one redis instance is saving data at a time.
+// hosts and then runs BGSAVE on them in a loop, waiting for each save to
+// complete before considering that host done for the pass. Up to -parallel
+// hosts may save concurrently, but at most one host per group ever saves at
+// the same time; see target.group. By default (-prefer replica) a BGSAVE
+// against a master with a healthy replica is redirected to that replica; see
+// chooseSaveTarget. When -post-save-cmd or -s3-bucket is set, each
+// successful save is followed by an upload of the resulting dump.rdb; see
+// postSaveUpload in upload.go.
+//
+// Addresses are read one per line from the file named by -file. Each line may
+// be a plain host:port pair, or a redis://, rediss://, sentinel://, cluster://
+// or clusters:// URI, optionally preceded by a "group=name " tag; see
+// resolveTargets for the accepted forms. Each resolved target keeps a single
+// long-lived *redis.Client (go-redis) for the lifetime of the run, so
+// connections are pooled and reused across passes instead of being redialed
+// every time.
 package main
 
 import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
-	"log"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
 	"math/rand"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/artyom/autoflags"
-	"github.com/artyom/logger"
-	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/semaphore"
 )
 
 func main() {
 	args := struct {
-		Deadline  time.Duration `flag:"deadline,exit after working for this long"`
-		Addresses string        `flag:"file,file with redis addresses (host:port), one per line"`
+		Deadline      time.Duration `flag:"deadline,exit after working for this long"`
+		Addresses     string        `flag:"file,file with redis addresses, one per line (host:port, redis://, rediss://, sentinel://, cluster:// or clusters://)"`
+		TLSCert       string        `flag:"tls-cert,client certificate file for rediss:// targets"`
+		TLSKey        string        `flag:"tls-key,client key file for rediss:// targets"`
+		TLSCA         string        `flag:"tls-ca,CA bundle used to verify rediss:// targets"`
+		Parallel      int           `flag:"parallel,maximum number of hosts saving concurrently"`
+		Prefer        string        `flag:"prefer,prefer saving from 'replica', 'master' or 'any' node"`
+		MaxReplicaLag time.Duration `flag:"max-replica-lag,skip replicas whose reported lag exceeds this when choosing a save target"`
+		PostSaveCmd   string        `flag:"post-save-cmd,template run after each save with .Addr, .Dir and .Dbfilename; overrides the built-in SSH uploader"`
+		SSHUser       string        `flag:"ssh-user,user for the built-in SSH uploader"`
+		SSHKey        string        `flag:"ssh-key,private key file for the built-in SSH uploader"`
+		S3Endpoint    string        `flag:"s3-endpoint,S3-compatible endpoint URL for uploaded backups"`
+		S3Region      string        `flag:"s3-region,region to use when signing S3 requests"`
+		S3Bucket      string        `flag:"s3-bucket,bucket to upload dump.rdb files to after each save"`
+		Retain        int           `flag:"retain,keep at most this many uploaded backups per host (0: keep all)"`
+		LogFormat     string        `flag:"log-format,log output format: 'text' or 'json'"`
+		MetricsAddr   string        `flag:"metrics-addr,address to serve Prometheus metrics on (empty disables)"`
 	}{
-		Deadline: time.Hour,
+		Deadline:      time.Hour,
+		Parallel:      1,
+		Prefer:        "replica",
+		MaxReplicaLag: 30 * time.Second,
+		SSHUser:       "root",
+		LogFormat:     "text",
 	}
 	autoflags.Parse(&args)
 	if args.Deadline < time.Minute {
 		args.Deadline = time.Minute
 	}
-	log := log.New(os.Stderr, "", log.LstdFlags)
-	addrs, err := readLines(args.Addresses)
+	if args.Parallel < 1 {
+		args.Parallel = 1
+	}
+	log, err := newLogger(args.LogFormat)
+	if err != nil {
+		fatal(slog.Default(), "invalid -log-format", "error", err)
+	}
+	switch args.Prefer {
+	case "replica", "master", "any":
+	default:
+		fatal(log, "invalid -prefer", "prefer", args.Prefer)
+	}
+	lines, err := readLines(args.Addresses)
+	if err != nil {
+		fatal(log, "reading -file", "error", err)
+	}
+	tlsConf, err := tlsConfig(args.TLSCert, args.TLSKey, args.TLSCA)
+	if err != nil {
+		fatal(log, "building TLS config", "error", err)
+	}
+	targets, err := resolveTargets(lines, tlsConf)
 	if err != nil {
-		log.Fatal(err)
+		fatal(log, "resolving targets", "error", err)
 	}
+	defer func() {
+		for _, t := range targets {
+			t.client.Close()
+		}
+	}()
 	rand.Seed(time.Now().Unix())
-	for i := range addrs {
+	for i := range targets {
 		j := rand.Intn(i + 1)
-		addrs[i], addrs[j] = addrs[j], addrs[i]
+		targets[i], targets[j] = targets[j], targets[i]
+	}
+	if args.MetricsAddr != "" {
+		go serveMetrics(args.MetricsAddr, log)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), args.Deadline)
 	defer cancel()
-	if err := do(ctx, log, addrs); err != nil {
+	upload := uploadConfig{
+		PostSaveCmd: args.PostSaveCmd,
+		SSHUser:     args.SSHUser,
+		SSHKey:      args.SSHKey,
+		S3Endpoint:  args.S3Endpoint,
+		S3Region:    args.S3Region,
+		S3Bucket:    args.S3Bucket,
+		Retain:      args.Retain,
+	}
+	if err := do(ctx, log, targets, args.Parallel, args.Prefer, args.MaxReplicaLag, upload); err != nil {
 		if err == context.DeadlineExceeded {
-			log.Print("deadline of %v reached", args.Deadline)
+			log.Info("deadline reached", "deadline", args.Deadline)
 			return
 		}
-		log.Fatal(err)
+		fatal(log, "fatal error", "error", err)
 	}
 }
 
-func do(ctx context.Context, log logger.Interface, addrs []string) error {
-	if len(addrs) == 0 {
+// newLogger builds the structured logger used throughout the program.
+// format must be "text" or "json".
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q: want text or json", format)
+	}
+}
+
+// fatal logs msg at error level and exits with status 1.
+func fatal(log *slog.Logger, msg string, args ...any) {
+	log.Error(msg, args...)
+	os.Exit(1)
+}
+
+// do disables persistence on every target, then repeatedly runs BGSAVE
+// across them, up to parallel hosts at a time. Each group (see target.group)
+// is worked through by its own single goroutine, one member at a time, so
+// two targets sharing a group (e.g. a replica set's members) are never
+// saving concurrently — and, unlike gating on a per-group mutex acquired
+// after a semaphore permit, a busy group never ties up a permit that another
+// group could otherwise use: the permit is only acquired immediately before
+// that group's current target actually starts saving. When chooseSaveTarget
+// redirects a save to a replica, persistence is also disabled on that
+// replica before BGSAVE runs against it, since the upfront pass only covers
+// the configured targets.
+func do(ctx context.Context, log *slog.Logger, targets []target, parallel int, prefer string, maxReplicaLag time.Duration, upload uploadConfig) error {
+	if len(targets) == 0 {
 		return errors.New("empty addresses")
 	}
-	for _, addr := range addrs {
-		if err := disablePersistence(addr); err != nil {
-			log.Printf("%s: %v", addr, err)
+	for _, t := range targets {
+		err := disablePersistence(ctx, t)
+		metricPersistenceDisabled.WithLabelValues(t.addr, t.group()).Set(boolToFloat(err == nil))
+		if err != nil {
+			log.Error("disabling persistence", "addr", t.addr, "group", t.group(), "error", err)
 		}
 	}
+	groups := groupByName(targets)
 	for {
-		for _, addr := range addrs {
-			begin := time.Now()
-			if err := saveBlocking(ctx, addr); err != nil {
-				log.Printf("%s: %v", addr, err)
-			} else {
-				log.Printf("%s: saved in %v", addr, time.Since(begin))
-			}
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+		if err := runGroups(ctx, groups, parallel, func(t target) {
+			saveOne(ctx, log, t, prefer, maxReplicaLag, upload)
+		}); err != nil {
+			return err
 		}
 		select {
 		case <-ctx.Done():
@@ -81,27 +186,118 @@ func do(ctx context.Context, log logger.Interface, addrs []string) error {
 	}
 }
 
-func saveBlocking(ctx context.Context, addr string) error {
-	conn, err := redis.DialTimeout("tcp", addr, 15*time.Second)
+// groupByName buckets targets by target.group, preserving each group's
+// relative order from targets so members of a replica set or cluster shard
+// are still saved in the order operators listed them.
+func groupByName(targets []target) map[string][]target {
+	groups := make(map[string][]target)
+	for _, t := range targets {
+		groups[t.group()] = append(groups[t.group()], t)
+	}
+	return groups
+}
+
+// runGroups calls work once for every target across groups, one goroutine
+// per group working through its members in order, while capping how many
+// targets are actually being worked on at once, across all groups, to
+// parallel. The semaphore permit is only held for the duration of a single
+// work call, not for however long it takes that target's group to become
+// free, so a group busy serializing its own members never ties up a permit
+// another group could use to make progress. Returns ctx.Err() if ctx is
+// cancelled before every target has been worked on.
+func runGroups(ctx context.Context, groups map[string][]target, parallel int, work func(target)) error {
+	sem := semaphore.NewWeighted(int64(parallel))
+	var wg sync.WaitGroup
+	var cancelled atomic.Bool
+	for _, members := range groups {
+		wg.Add(1)
+		go func(members []target) {
+			defer wg.Done()
+			for _, t := range members {
+				if err := sem.Acquire(ctx, 1); err != nil {
+					cancelled.Store(true)
+					return
+				}
+				work(t)
+				sem.Release(1)
+			}
+		}(members)
+	}
+	wg.Wait()
+	if cancelled.Load() {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// saveOne picks where to actually run BGSAVE for t (see chooseSaveTarget),
+// runs it, uploads the result and records metrics, logging as it goes.
+// Errors are logged rather than returned: one target failing must not stop
+// do's other group goroutines from making progress.
+func saveOne(ctx context.Context, log *slog.Logger, t target, prefer string, maxReplicaLag time.Duration, upload uploadConfig) {
+	scrapeRedisMetrics(ctx, t)
+	save, err := chooseSaveTarget(ctx, t, prefer, maxReplicaLag)
 	if err != nil {
-		return err
+		log.Error("choosing save target", "addr", t.addr, "group", t.group(), "error", err)
+		save = t
+	} else if save.addr != t.addr {
+		log.Info("redirecting save to replica", "addr", t.addr, "group", t.group(), "replica", save.addr)
+	}
+	if save.ephemeral {
+		defer save.client.Close()
 	}
-	defer conn.Close()
-	prev, err := conn.Cmd("LASTSAVE").Int64()
+	if save.addr != t.addr {
+		err := disablePersistence(ctx, save)
+		metricPersistenceDisabled.WithLabelValues(save.addr, t.group()).Set(boolToFloat(err == nil))
+		if err != nil {
+			log.Error("disabling persistence", "addr", save.addr, "group", t.group(), "error", err)
+		}
+	}
+	metricBGSaveInProgress.WithLabelValues(save.addr, t.group()).Set(1)
+	begin := time.Now()
+	err = saveBlocking(ctx, save)
+	metricBGSaveInProgress.WithLabelValues(save.addr, t.group()).Set(0)
+	duration := time.Since(begin)
+	metricSaveDuration.WithLabelValues(save.addr, t.group()).Observe(duration.Seconds())
+	if err != nil {
+		metricSaveFailures.WithLabelValues(save.addr, t.group()).Inc()
+		log.Error("save failed", "addr", save.addr, "group", t.group(), "duration_ms", duration.Milliseconds(), "error", err)
+		return
+	}
+	metricLastSaveTimestamp.WithLabelValues(save.addr, t.group()).SetToCurrentTime()
+	log.Info("saved", "addr", save.addr, "group", t.group(), "duration_ms", duration.Milliseconds())
+	if err := postSaveUpload(ctx, save, upload, log); err != nil {
+		log.Error("post-save upload", "addr", save.addr, "group", t.group(), "error", err)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// saveBlocking issues BGSAVE against t and waits for it to complete, noticed
+// as a change in LASTSAVE. Every call is made through ctx, so a cancelled
+// deadline interrupts the poll immediately instead of only between network
+// reads.
+func saveBlocking(ctx context.Context, t target) error {
+	prev, err := t.client.LastSave(ctx).Result()
 	if err != nil {
 		return err
 	}
-	if err := conn.Cmd("BGSAVE").Err; err != nil {
+	if err := t.client.BgSave(ctx).Err(); err != nil {
 		return err
 	}
-	t := time.NewTicker(10 * time.Second)
-	defer t.Stop()
+	tick := time.NewTicker(10 * time.Second)
+	defer tick.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-t.C:
-			cur, err := conn.Cmd("LASTSAVE").Int64()
+		case <-tick.C:
+			cur, err := t.client.LastSave(ctx).Result()
 			if err != nil {
 				return err
 			}
@@ -112,13 +308,330 @@ func saveBlocking(ctx context.Context, addr string) error {
 	}
 }
 
-func disablePersistence(addr string) error {
-	conn, err := redis.DialTimeout("tcp", addr, 5*time.Second)
+// disablePersistence clears the save points of t. It is idempotent: if
+// CONFIG GET save already reports no save points, it leaves the node alone
+// rather than re-issuing CONFIG SET.
+func disablePersistence(ctx context.Context, t target) error {
+	cur, err := t.client.ConfigGet(ctx, "save").Result()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-	return conn.Cmd("CONFIG", "SET", "SAVE", "").Err
+	if v, ok := cur["save"]; ok && v == "" {
+		return nil
+	}
+	return t.client.ConfigSet(ctx, "save", "").Err()
+}
+
+// chooseSaveTarget decides which node a BGSAVE should actually be issued
+// against. With prefer == "master" it always returns t unchanged. Otherwise
+// it asks t for its replication role; if t is a master with at least one
+// replica whose lag does not exceed maxReplicaLag, the save is redirected to
+// that replica to avoid the fork/COW memory spike on the primary. If no
+// replica qualifies, prefer == "replica" falls back to saving on the master
+// (prefer == "any" does the same, silently). The replica's client reuses t's
+// TLS config and credentials, since a redirected target is assumed to share
+// them with its master. A redirected target's client is marked ephemeral so
+// the caller closes it once the save is done.
+func chooseSaveTarget(ctx context.Context, t target, prefer string, maxReplicaLag time.Duration) (target, error) {
+	if prefer == "master" {
+		return t, nil
+	}
+	role, err := t.client.Do(ctx, "ROLE").Slice()
+	if err != nil {
+		return t, err
+	}
+	kind, _ := role[0].(string)
+	if kind != "master" {
+		return t, nil
+	}
+	info, err := t.client.Info(ctx, "replication").Result()
+	if err != nil {
+		return t, err
+	}
+	addr, ok := healthyReplica(info, maxReplicaLag)
+	if !ok {
+		return t, nil
+	}
+	opts := t.client.Options()
+	replica := t
+	replica.addr = addr
+	replica.client = newRedisClient(addr, opts.TLSConfig, opts.Username, opts.Password)
+	replica.ephemeral = true
+	return replica, nil
+}
+
+// healthyReplica parses the output of INFO replication and returns the
+// address of the first connected replica whose reported lag does not exceed
+// maxLag.
+func healthyReplica(info string, maxLag time.Duration) (string, bool) {
+	maxLagSec := int(maxLag / time.Second)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "slave") {
+			continue
+		}
+		_, fields, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		kv := parseFieldList(fields)
+		if kv["state"] != "online" || kv["ip"] == "" || kv["port"] == "" {
+			continue
+		}
+		lag, err := strconv.Atoi(kv["lag"])
+		if err != nil || lag > maxLagSec {
+			continue
+		}
+		return net.JoinHostPort(kv["ip"], kv["port"]), true
+	}
+	return "", false
+}
+
+// parseFieldList parses a "k1=v1,k2=v2" list as used in INFO replication
+// slave lines.
+func parseFieldList(s string) map[string]string {
+	out := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// target is a single concrete redis instance to operate on, resolved from one
+// address line by resolveTargets, together with the long-lived client used
+// to talk to it.
+type target struct {
+	addr      string // host:port, or "sentinel:name" for a sentinel-backed target
+	grp       string // explicit group= tag from the address line, if any
+	client    *redis.Client
+	ephemeral bool // client was created ad hoc (e.g. a redirected replica) and must be closed after use
+}
+
+func (t target) String() string { return t.addr }
+
+// group returns the name used to serialize saves against other targets that
+// must never run concurrently (e.g. members of the same replica set or
+// cluster shard). Targets without an explicit group= tag are their own
+// group, which imposes no extra serialization beyond -parallel.
+func (t target) group() string {
+	if t.grp != "" {
+		return t.grp
+	}
+	return t.addr
+}
+
+// newRedisClient builds the single long-lived client used for a target for
+// the remainder of the run.
+func newRedisClient(addr string, tlsConf *tls.Config, user, pass string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Username:     user,
+		Password:     pass,
+		TLSConfig:    tlsConf,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	})
+}
+
+// resolveTargets expands each address line into one or more concrete
+// targets. A line may start with a "group=name " tag naming the group (see
+// target.group) all targets resolved from the rest of the line belong to;
+// this is how a static replica set or cluster shard list can be marked as
+// "at most one save at a time" without relying on the default one-group-
+// per-host behaviour. The remainder of the line is one of:
+//
+//	host:port                                    plain TCP, no TLS, no auth
+//	redis://[user:pass@]host:port                plain TCP
+//	rediss://[user:pass@]host:port                TLS, using tlsConf
+//	sentinel://[user:pass@]master-name@host1:26379,host2...
+//	                                              a failover-aware client
+//	                                              that always talks to the
+//	                                              current master of
+//	                                              master-name
+//	cluster://[user:pass@]host:port              expanded to the primary of
+//	clusters://[user:pass@]host:port             every shard of the cluster
+//	                                              host:port belongs to;
+//	                                              clusters:// uses tlsConf
+//	                                              for every discovered node
+func resolveTargets(lines []string, tlsConf *tls.Config) ([]target, error) {
+	var out []target
+	for _, line := range lines {
+		grp, line := splitGroup(line)
+		switch {
+		case strings.HasPrefix(line, "sentinel://"):
+			t, err := resolveSentinel(line, tlsConf)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			t.grp = grp
+			out = append(out, t)
+		case strings.HasPrefix(line, "cluster://"), strings.HasPrefix(line, "clusters://"):
+			seed, user, pass, useTLS, err := parseRedisURI(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			conf := (*tls.Config)(nil)
+			if useTLS {
+				conf = tlsConf
+				if conf == nil {
+					conf = &tls.Config{}
+				}
+			}
+			ts, err := resolveCluster(seed, conf, user, pass)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			for i := range ts {
+				ts[i].grp = grp
+			}
+			out = append(out, ts...)
+		case strings.HasPrefix(line, "redis://"), strings.HasPrefix(line, "rediss://"):
+			addr, user, pass, useTLS, err := parseRedisURI(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			conf := (*tls.Config)(nil)
+			if useTLS {
+				conf = tlsConf
+				if conf == nil {
+					conf = &tls.Config{}
+				}
+			}
+			out = append(out, target{addr: addr, grp: grp, client: newRedisClient(addr, conf, user, pass)})
+		default:
+			out = append(out, target{addr: line, grp: grp, client: newRedisClient(line, nil, "", "")})
+		}
+	}
+	return out, nil
+}
+
+// parseRedisURI splits a redis://, rediss://, cluster:// or clusters:// URI
+// into its address and optional username/password. The "s"-suffixed schemes
+// (rediss, clusters) indicate TLS.
+func parseRedisURI(raw string) (addr, user, pass string, useTLS bool, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", false, err
+	}
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	useTLS = u.Scheme == "rediss" || u.Scheme == "clusters"
+	return u.Host, user, pass, useTLS, nil
+}
+
+// splitGroup splits a "group=name rest-of-line" address line into its group
+// tag and the remaining address spec. Lines without the tag are returned
+// unchanged with an empty group.
+func splitGroup(line string) (group, rest string) {
+	if !strings.HasPrefix(line, "group=") {
+		return "", line
+	}
+	line = strings.TrimPrefix(line, "group=")
+	name, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return "", "group=" + line
+	}
+	return name, strings.TrimSpace(rest)
+}
+
+// resolveSentinel asks the sentinels listed in a
+// sentinel://master-name@host1:port1,host2:port2,... URI for the address
+// currently holding the master role for master-name.
+func resolveSentinel(uri string, tlsConf *tls.Config) (target, error) {
+	rest := strings.TrimPrefix(uri, "sentinel://")
+	var user, pass string
+	if parts := strings.Split(rest, "@"); len(parts) == 3 {
+		if u, p, ok := strings.Cut(parts[0], ":"); ok {
+			user, pass = u, p
+		} else {
+			user = parts[0]
+		}
+		rest = parts[1] + "@" + parts[2]
+	}
+	name, hostsPart, ok := strings.Cut(rest, "@")
+	if !ok || name == "" || hostsPart == "" {
+		return target{}, errors.New("expected sentinel://[user:pass@]master-name@host1:port1,host2:port2")
+	}
+	hosts := strings.Split(hostsPart, ",")
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    name,
+		SentinelAddrs: hosts,
+		Username:      user,
+		Password:      pass,
+		TLSConfig:     tlsConf,
+		DialTimeout:   5 * time.Second,
+		ReadTimeout:   15 * time.Second,
+		WriteTimeout:  15 * time.Second,
+	})
+	return target{addr: "sentinel:" + name, client: client}, nil
+}
+
+// resolveCluster connects to the given cluster seed node and, via CLUSTER
+// SLOTS, discovers the primary of every shard so callers can point at a
+// single cluster endpoint instead of maintaining a static host list. tlsConf,
+// user and pass (parsed from the cluster://, clusters:// line by the caller)
+// are applied both to the seed connection and to every discovered primary,
+// since cluster nodes share the same TLS/ACL configuration.
+func resolveCluster(seed string, tlsConf *tls.Config, user, pass string) ([]target, error) {
+	seedClient := newRedisClient(seed, tlsConf, user, pass)
+	defer seedClient.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	slots, err := seedClient.ClusterSlots(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var out []target
+	for _, slot := range slots {
+		if len(slot.Nodes) == 0 {
+			continue
+		}
+		addr := slot.Nodes[0].Addr
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		out = append(out, target{addr: addr, client: newRedisClient(addr, tlsConf, user, pass)})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no primaries discovered from %s", seed)
+	}
+	return out, nil
+}
+
+// tlsConfig builds the TLS configuration used for rediss:// targets. It
+// returns a nil config (callers fall back to tls.Config{}) when none of the
+// flags are set.
+func tlsConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
 }
 
 func readLines(name string) ([]string, error) {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseFieldList(t *testing.T) {
+	got := parseFieldList("ip=10.0.0.1,port=6379,state=online,offset=123,lag=0")
+	want := map[string]string{"ip": "10.0.0.1", "port": "6379", "state": "online", "offset": "123", "lag": "0"}
+	if len(got) != len(want) {
+		t.Fatalf("parseFieldList() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("parseFieldList()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestHealthyReplica(t *testing.T) {
+	cases := []struct {
+		name     string
+		info     string
+		maxLag   time.Duration
+		wantAddr string
+		wantOK   bool
+	}{
+		{
+			name:     "no replicas",
+			info:     "role:master\r\nconnected_slaves:0\r\n",
+			maxLag:   30 * time.Second,
+			wantAddr: "",
+			wantOK:   false,
+		},
+		{
+			name:     "healthy replica",
+			info:     "role:master\r\nconnected_slaves:1\r\nslave0:ip=10.0.0.1,port=6379,state=online,offset=100,lag=1\r\n",
+			maxLag:   30 * time.Second,
+			wantAddr: "10.0.0.1:6379",
+			wantOK:   true,
+		},
+		{
+			name:     "replica lag too high",
+			info:     "role:master\r\nslave0:ip=10.0.0.1,port=6379,state=online,offset=100,lag=60\r\n",
+			maxLag:   30 * time.Second,
+			wantAddr: "",
+			wantOK:   false,
+		},
+		{
+			name:     "replica not online skipped in favor of next",
+			info:     "role:master\r\nslave0:ip=10.0.0.1,port=6379,state=wait_bgsave,offset=100,lag=0\r\nslave1:ip=10.0.0.2,port=6379,state=online,offset=100,lag=0\r\n",
+			maxLag:   30 * time.Second,
+			wantAddr: "10.0.0.2:6379",
+			wantOK:   true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, ok := healthyReplica(c.info, c.maxLag)
+			if addr != c.wantAddr || ok != c.wantOK {
+				t.Errorf("healthyReplica() = (%q, %v), want (%q, %v)", addr, ok, c.wantAddr, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestChooseSaveTargetPreferMaster(t *testing.T) {
+	// prefer == "master" must return t unchanged without touching t.client,
+	// so a nil client is safe here.
+	in := target{addr: "host:6379"}
+	out, err := chooseSaveTarget(context.Background(), in, "master", 30*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != in {
+		t.Errorf("chooseSaveTarget() = %+v, want %+v unchanged", out, in)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/crypto/ssh"
+)
+
+// uploadConfig collects the flags controlling the optional post-save upload
+// of dump.rdb to an S3-compatible bucket. Config is considered disabled when
+// both PostSaveCmd and S3Bucket are empty.
+type uploadConfig struct {
+	PostSaveCmd string // text/template run instead of the built-in SSH+S3 uploader
+	SSHUser     string
+	SSHKey      string // path to a private key file
+	S3Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com, or a MinIO URL
+	S3Region    string
+	S3Bucket    string
+	Retain      int // keep at most this many backups per host; 0 disables rotation
+}
+
+func (c uploadConfig) enabled() bool {
+	return c.PostSaveCmd != "" || c.S3Bucket != ""
+}
+
+// postSaveUpload runs after a successful BGSAVE on t. It fetches the node's
+// RDB directory and filename, then either runs cfg.PostSaveCmd (a
+// user-supplied template receiving .Addr, .Dir and .Dbfilename) or, if
+// that's unset, copies the file over SSH and uploads it to cfg.S3Bucket
+// itself, verifying the transfer by checksum and rotating old backups.
+func postSaveUpload(ctx context.Context, t target, cfg uploadConfig, log *slog.Logger) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	dir, err := configGet(ctx, t, "dir")
+	if err != nil {
+		return fmt.Errorf("CONFIG GET dir: %w", err)
+	}
+	dbfilename, err := configGet(ctx, t, "dbfilename")
+	if err != nil {
+		return fmt.Errorf("CONFIG GET dbfilename: %w", err)
+	}
+	var upErr error
+	if cfg.PostSaveCmd != "" {
+		upErr = runPostSaveCmd(ctx, cfg.PostSaveCmd, t, dir, dbfilename)
+	} else {
+		upErr = sshUploadToS3(ctx, t, dir, dbfilename, cfg)
+	}
+	if upErr != nil {
+		metricBackupsFailed.WithLabelValues(t.addr, t.group()).Inc()
+		return upErr
+	}
+	metricBackupsUploaded.WithLabelValues(t.addr, t.group()).Inc()
+	log.Info("uploaded backup", "addr", t.addr, "dir", dir, "dbfilename", dbfilename)
+	return nil
+}
+
+func configGet(ctx context.Context, t target, param string) (string, error) {
+	fields, err := t.client.ConfigGet(ctx, param).Result()
+	if err != nil {
+		return "", err
+	}
+	v, ok := fields[param]
+	if !ok {
+		return "", fmt.Errorf("unexpected CONFIG GET %s reply", param)
+	}
+	return v, nil
+}
+
+// runPostSaveCmd renders cmdTemplate with the node's address, RDB directory
+// and filename, then executes it through the shell, inheriting stdout/stderr
+// to the parent process so operators see the command's own logging.
+func runPostSaveCmd(ctx context.Context, cmdTemplate string, t target, dir, dbfilename string) error {
+	tmpl, err := template.New("post-save-cmd").Parse(cmdTemplate)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, struct{ Addr, Dir, Dbfilename string }{t.addr, dir, dbfilename})
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", buf.String())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// sshUploadToS3 is the built-in uploader used when -post-save-cmd is not
+// set: it reads the RDB file from the node over SSH and streams it straight
+// into cfg.S3Bucket, then verifies the upload's checksum matches what was
+// read on the remote side, deleting the object it just uploaded if it
+// doesn't (rather than leaving a corrupt backup in the bucket, where it
+// could count toward rotateBackups' retention window and push out a good
+// one), before rotating old backups.
+func sshUploadToS3(ctx context.Context, t target, dir, dbfilename string, cfg uploadConfig) error {
+	host, err := splitHostPort(t.addr)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(cfg.SSHKey)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(host, "22"), &ssh.ClientConfig{
+		User:            cfg.SSHUser,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("ssh dial: %w", err)
+	}
+	defer client.Close()
+
+	remotePath := dir + "/" + dbfilename
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	sumOut, err := session.CombinedOutput("sha256sum " + shellQuote(remotePath))
+	session.Close()
+	if err != nil {
+		return fmt.Errorf("sha256sum: %w", err)
+	}
+	remoteSum := strings.Fields(string(sumOut))
+	if len(remoteSum) == 0 {
+		return errors.New("sha256sum: empty output")
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("cat " + shellQuote(remotePath)); err != nil {
+		return err
+	}
+
+	// Stream the RDB straight from the SSH session's stdout into the S3
+	// upload without ever holding the whole file in memory: pr/pw form the
+	// pipe the uploader reads from, and h hashes the bytes as they pass
+	// through rather than after the fact.
+	pr, pw := io.Pipe()
+	h := sha256.New()
+	go func() {
+		_, copyErr := io.Copy(io.MultiWriter(h, pw), stdout)
+		waitErr := session.Wait()
+		switch {
+		case copyErr != nil:
+			pw.CloseWithError(copyErr)
+		case waitErr != nil:
+			pw.CloseWithError(fmt.Errorf("cat %s: %w", remotePath, waitErr))
+		default:
+			pw.Close()
+		}
+	}()
+
+	key2 := t.addr + "/" + time.Now().UTC().Format("20060102T150405Z") + "-" + dbfilename
+	s3c := s3.New(s3.Options{
+		Region:       cfg.S3Region,
+		BaseEndpoint: aws.String(cfg.S3Endpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), ""),
+	})
+	uploader := manager.NewUploader(s3c)
+	if _, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(cfg.S3Bucket),
+		Key:    aws.String(key2),
+		Body:   pr,
+	}); err != nil {
+		return fmt.Errorf("s3 upload: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != remoteSum[0] {
+		if _, delErr := s3c.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(cfg.S3Bucket),
+			Key:    aws.String(key2),
+		}); delErr != nil {
+			return fmt.Errorf("checksum mismatch for %s: read %s, remote reports %s (also failed to delete corrupt upload %s: %v)", remotePath, got, remoteSum[0], key2, delErr)
+		}
+		return fmt.Errorf("checksum mismatch for %s: read %s, remote reports %s; deleted corrupt upload %s", remotePath, got, remoteSum[0], key2)
+	}
+	if cfg.Retain > 0 {
+		if err := rotateBackups(ctx, s3c, cfg.S3Bucket, t.addr, cfg.Retain); err != nil {
+			return fmt.Errorf("rotate backups: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotateBackups keeps only the retain most recent objects under prefix in
+// bucket, deleting the rest. Object keys are produced by sshUploadToS3 as
+// "<addr>/<timestamp>-<dbfilename>", so lexical order is chronological
+// order.
+func rotateBackups(ctx context.Context, s3c *s3.Client, bucket, prefix string, retain int) error {
+	out, err := s3c.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix + "/"),
+	})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(out.Contents))
+	for _, obj := range out.Contents {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	sort.Strings(keys)
+	if len(keys) <= retain {
+		return nil
+	}
+	for _, key := range keys[:len(keys)-retain] {
+		if _, err := s3c.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func splitHostPort(addr string) (host string, err error) {
+	host, _, err = net.SplitHostPort(addr)
+	return host, err
+}
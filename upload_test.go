@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"/var/lib/redis/dump.rdb", "'/var/lib/redis/dump.rdb'"},
+		{"it's a test", `'it'\''s a test'`},
+	}
+	for _, c := range cases {
+		if got := shellQuote(c.in); got != c.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	host, err := splitHostPort("10.0.0.1:6379")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if host != "10.0.0.1" {
+		t.Errorf("host = %q, want %q", host, "10.0.0.1")
+	}
+	if _, err := splitHostPort("not-a-valid-addr"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
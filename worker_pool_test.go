@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupByName(t *testing.T) {
+	targets := []target{
+		{addr: "a1", grp: "shard-a"},
+		{addr: "a2", grp: "shard-a"},
+		{addr: "b1", grp: "shard-b"},
+	}
+	groups := groupByName(targets)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if got := groups["shard-a"]; len(got) != 2 || got[0].addr != "a1" || got[1].addr != "a2" {
+		t.Errorf("shard-a group = %v, want [a1 a2] in order", got)
+	}
+	if got := groups["shard-b"]; len(got) != 1 || got[0].addr != "b1" {
+		t.Errorf("shard-b group = %v, want [b1]", got)
+	}
+}
+
+// TestRunGroupsDoesNotStarveOtherGroups lists two targets in the same group
+// consecutively, as a replica set's members would be, alongside a target in
+// its own group. With a busy group gating on its own mutex after already
+// consuming a semaphore permit, group-b's lone target would get stuck behind
+// an idle permit held by a goroutine blocked on group-a's serialization; it
+// should instead be free to run concurrently with group-a.
+func TestRunGroupsDoesNotStarveOtherGroups(t *testing.T) {
+	targets := []target{
+		{addr: "a1", grp: "shard-a"},
+		{addr: "a2", grp: "shard-a"},
+		{addr: "b1", grp: "shard-b"},
+	}
+	groups := groupByName(targets)
+
+	const step = 50 * time.Millisecond
+	var mu sync.Mutex
+	running := make(map[string]bool)
+	var sameGroupOverlap, crossGroupOverlap bool
+
+	work := func(t target) {
+		mu.Lock()
+		for grp, active := range running {
+			if !active {
+				continue
+			}
+			if grp == t.group() {
+				sameGroupOverlap = true
+			} else {
+				crossGroupOverlap = true
+			}
+		}
+		running[t.group()] = true
+		mu.Unlock()
+
+		time.Sleep(step)
+
+		mu.Lock()
+		running[t.group()] = false
+		mu.Unlock()
+	}
+
+	start := time.Now()
+	if err := runGroups(context.Background(), groups, 2, work); err != nil {
+		t.Fatalf("runGroups: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if sameGroupOverlap {
+		t.Error("two members of the same group ran concurrently")
+	}
+	if !crossGroupOverlap {
+		t.Error("shard-b never overlapped with shard-a; a busy group appears to be starving other groups of permits")
+	}
+	// shard-a alone takes 2*step, serialized. If shard-b is running
+	// concurrently with it as intended, the whole thing still takes ~2*step;
+	// if a busy group can starve others of permits, it takes ~3*step.
+	if elapsed > 2*step+step/2 {
+		t.Errorf("runGroups took %v, want close to %v (groups should run concurrently)", elapsed, 2*step)
+	}
+}
+
+// TestRunGroupsContextCancel checks runGroups surfaces ctx's error once
+// cancellation prevents every target from being worked on.
+func TestRunGroupsContextCancel(t *testing.T) {
+	targets := []target{{addr: "a1", grp: "shard-a"}, {addr: "a2", grp: "shard-a"}}
+	groups := groupByName(targets)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := runGroups(ctx, groups, 1, func(target) {}); err != context.Canceled {
+		t.Errorf("runGroups() = %v, want %v", err, context.Canceled)
+	}
+}